@@ -3,50 +3,47 @@ package pool
 import (
 	"net"
 	"sync"
-
-	log "github.com/sirupsen/logrus"
+	"sync/atomic"
+	"time"
 )
 
+// connOwner is implemented by anything that lends out a GConn and wants it
+// back on Close - a single-address Pool or a keyed MultiPool.
+type connOwner interface {
+	releaseConn(conn net.Conn, created time.Time, unusable bool) error
+}
+
 // GConn wrap net.Conn to borrow or return conn
 type GConn struct {
 	// wrap real connection
 	net.Conn
-	// gpool
-	p *Pool
-	//sync pool put or get
-	mu sync.RWMutex
-	// identify an GConn usable or can close
-	unusable bool
+	// owner the GConn was borrowed from
+	owner connOwner
+	// created is when the underlying conn was dialed, used by the owner to
+	// enforce things like MaxConnLifetime
+	created time.Time
+	// logger is inherited from the owning pool
+	logger Logger
+	// unusable marks the connection for closing instead of returning to the
+	// pool on Close
+	unusable atomic.Bool
+	// closeOnce makes Close idempotent: a double-Close, or a Close after
+	// MarkUnusable, only ever releases the conn to its owner once
+	closeOnce sync.Once
+	closeErr  error
 }
 
 // Close puts the given connects back to the pool instead of closing it.
 func (g *GConn) Close() error {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-
-	log.WithFields(log.Fields{
-		"Connection Id": g.Conn,
-		"Address":       g.Conn.RemoteAddr(),
-	}).Info("Closing connection")
-
-	if g.unusable {
-		if g.Conn != nil {
-			g.p.addRemainingSpace()
-			return g.Conn.Close()
-		}
-		return nil
-	}
-	return g.p.Return(g.Conn)
+	g.closeOnce.Do(func() {
+		g.logger.Debugf("closing connection: address=%s unusable=%t", g.Conn.RemoteAddr(), g.unusable.Load())
+		g.closeErr = g.owner.releaseConn(g.Conn, g.created, g.unusable.Load())
+	})
+	return g.closeErr
 }
 
 // MarkUnusable marks the connection not usable any more, to let the pool close it instead of returning it to pool.
 func (g *GConn) MarkUnusable() {
-	log.WithFields(log.Fields{
-		"Connection Id": g.Conn,
-		"Address":       g.Conn.RemoteAddr(),
-		"Mark":          "Unusable",
-	}).Info("Marking connection")
-	g.mu.Lock()
-	g.unusable = true
-	g.mu.Unlock()
+	g.logger.Debugf("marking connection unusable: address=%s", g.Conn.RemoteAddr())
+	g.unusable.Store(true)
 }
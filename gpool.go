@@ -6,9 +6,8 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	log "github.com/sirupsen/logrus"
 )
 
 var (
@@ -30,22 +29,91 @@ type Config struct {
 	WaitTimeout time.Duration
 	// IdleTimeout is the timeout for a connection to be alive
 	IdleTimeout time.Duration
+	// MaxConnLifetime, if set, proactively closes a connection on Return once
+	// it has been alive longer than this, regardless of how idle it's been.
+	MaxConnLifetime time.Duration
+	// TestOnBorrow, if set, is run against a pooled connection before it is
+	// handed out by Get/BlockingGet. A non-nil error discards the connection
+	// and a replacement is dialed in its place.
+	TestOnBorrow func(c net.Conn, lastUsed time.Time) error
+	// Factory dials a new connection. It defaults to a plain net.Dialer when
+	// nil, but can be set to e.g. tls.Dial, a custom ALPN-aware dialer, a
+	// SOCKS proxy, or an in-memory pipe for tests.
+	Factory Factory
+	// Logger receives the pool's debug/operational chatter. Defaults to a
+	// no-op logger, so consumers aren't forced to depend on a concrete
+	// logging library.
+	Logger Logger
+}
+
+// pooledConn is the internal representation of an idle connection sitting on
+// p.conns. created is set once, when the conn is dialed, and never touched
+// again, so MaxConnLifetime stays anchored to the conn's actual age; lastUsed
+// is refreshed on every return and drives IdleTimeout/TestOnBorrow.
+type pooledConn struct {
+	conn     net.Conn
+	created  time.Time
+	lastUsed time.Time
 }
 
 //Pool store connections and pool info
 type Pool struct {
-	conns     chan net.Conn
-	factory   Factory
-	mu        sync.RWMutex
-	config    *Config
+	conns   chan *pooledConn
+	factory Factory
+	logger  Logger
+	mu      sync.RWMutex
+	config  *Config
+	// closed is set once, before conns is closed, so Return and friends can
+	// tell a concurrent Close apart from a full pool without racing on it.
+	closed    atomic.Bool
 	idleConns int
-	createNum int
+	// numOpen is the number of physical connections currently counted
+	// against MaxCap: incremented on every successful dial, decremented on
+	// every real net.Conn.Close the pool performs.
+	numOpen int
 	//will be used for blocking calls
 	remainingSpace chan bool
+	// waiters is a FIFO queue of callers parked waiting for a connection;
+	// Return hands a conn directly to waiters[0] instead of p.conns.
+	waiters []chan *GConn
+	// counters backing Stats()
+	waitCount    uint64
+	waitDuration time.Duration
+	timeoutCount uint64
+	createdTotal uint64
+	closedTotal  uint64
+}
+
+// Stats is a point-in-time snapshot of pool activity, modeled on sql.DBStats.
+type Stats struct {
+	Idle           int
+	InUse          int
+	Created        uint64
+	Closed         uint64
+	WaitCount      uint64
+	WaitDurationNS int64
+	TimeoutCount   uint64
+	MaxOpen        int
+}
+
+// Stats returns a snapshot of the pool's connection and wait-queue activity.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{
+		Idle:           p.idleConns,
+		InUse:          p.numOpen - p.idleConns,
+		Created:        p.createdTotal,
+		Closed:         p.closedTotal,
+		WaitCount:      p.waitCount,
+		WaitDurationNS: p.waitDuration.Nanoseconds(),
+		TimeoutCount:   p.timeoutCount,
+		MaxOpen:        p.config.MaxCap,
+	}
 }
 
 // Factory generate a new connection
-type Factory func(network, address string) (net.Conn, error)
+type Factory func(ctx context.Context, network, address string) (net.Conn, error)
 
 func (p *Pool) addRemainingSpace() {
 	p.remainingSpace <- true
@@ -55,21 +123,54 @@ func (p *Pool) removeRemainingSpace() {
 	<-p.remainingSpace
 }
 
+// noteDialed records a connection that was just successfully created.
+func (p *Pool) noteDialed() {
+	p.mu.Lock()
+	p.numOpen++
+	p.createdTotal++
+	p.mu.Unlock()
+}
+
+// noteClosed records a connection the pool just really closed (as opposed to
+// returned to the idle channel).
+func (p *Pool) noteClosed() {
+	p.mu.Lock()
+	p.numOpen--
+	p.closedTotal++
+	p.mu.Unlock()
+}
+
+func (p *Pool) incTimeout() {
+	p.mu.Lock()
+	p.timeoutCount++
+	p.mu.Unlock()
+}
+
 // New create a connection pool
 func New(pc *Config) (*Pool, error) {
 	// test initCap and maxCap
 	if pc.InitCap < 0 || pc.MaxCap < 0 || pc.InitCap > pc.MaxCap {
 		return nil, errors.New("invalid capacity setting")
 	}
+	logger := pc.Logger
+	if logger == nil {
+		logger = noopLogger{}
+	}
 	p := &Pool{
-		conns:          make(chan net.Conn, pc.MaxCap),
+		conns:          make(chan *pooledConn, pc.MaxCap),
 		config:         pc,
+		logger:         logger,
 		idleConns:      pc.InitCap,
 		remainingSpace: make(chan bool, pc.MaxCap),
 	}
 
-	p.factory = func(network, address string) (net.Conn, error) {
-		return net.Dial(network, address)
+	if pc.Factory != nil {
+		p.factory = pc.Factory
+	} else {
+		dialer := &net.Dialer{}
+		p.factory = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
 	}
 
 	//fill the remainingSpace channel so we can use it for blocking calls
@@ -79,36 +180,57 @@ func New(pc *Config) (*Pool, error) {
 
 	// create initial connection, if wrong just close it
 	for i := 0; i < pc.InitCap; i++ {
-		log.WithFields(log.Fields{
-			"Network": pc.Network,
-			"Address": pc.Address,
-		}).Info("Creating connection")
-		conn, err := p.factory(pc.Network, pc.Address)
+		p.logger.Debugf("creating connection: network=%s address=%s", pc.Network, pc.Address)
+		conn, err := p.factory(context.Background(), pc.Network, pc.Address)
 		p.removeRemainingSpace()
 		if err != nil {
 			p.Close()
 			p.addRemainingSpace()
 			return nil, fmt.Errorf("factory is not able to fill the pool: %s", err)
 		}
-		p.createNum = pc.InitCap
-		p.conns <- conn
+		p.noteDialed()
+		now := time.Now()
+		p.conns <- &pooledConn{conn: conn, created: now, lastUsed: now}
 	}
 	return p, nil
 }
 
 // wrapConn wraps a standard net.Conn to a poolConn net.Conn.
-func (p *Pool) wrapConn(conn net.Conn) *GConn {
-	log.WithFields(log.Fields{
-		"Connection Id": conn,
-		"Address":       conn.RemoteAddr(),
-	}).Info("Wrapping connection")
-	gconn := &GConn{p: p}
-	gconn.Conn = conn
-	return gconn
+func (p *Pool) wrapConn(conn net.Conn, created time.Time) *GConn {
+	p.logger.Debugf("wrapping connection: address=%s", conn.RemoteAddr())
+	return &GConn{Conn: conn, owner: p, created: created, logger: p.logger}
+}
+
+// releaseConn implements connOwner, handing a borrowed conn back to the pool
+// (or closing it if it was marked unusable or has outlived MaxConnLifetime).
+func (p *Pool) releaseConn(conn net.Conn, created time.Time, unusable bool) error {
+	if unusable {
+		if conn != nil {
+			p.addRemainingSpace()
+			p.noteClosed()
+			return conn.Close()
+		}
+		return nil
+	}
+	if p.config.MaxConnLifetime > 0 && !created.IsZero() && time.Since(created) > p.config.MaxConnLifetime {
+		p.logger.Debugf("connection past MaxConnLifetime, closing instead of returning: address=%s", conn.RemoteAddr())
+		p.addRemainingSpace()
+		p.noteClosed()
+		return conn.Close()
+	}
+	return p.returnConn(conn, created)
+}
+
+// SetFactory hot-swaps the pool's dial function, e.g. to switch to a
+// TLS-aware or proxy-aware Factory after the pool has already been created.
+func (p *Pool) SetFactory(f Factory) {
+	p.mu.Lock()
+	p.factory = f
+	p.mu.Unlock()
 }
 
 // getConnsAndFactory get conn channel and factory by once
-func (p *Pool) getConnsAndFactory() (chan net.Conn, Factory) {
+func (p *Pool) getConnsAndFactory() (chan *pooledConn, Factory) {
 	p.mu.RLock()
 	conns := p.conns
 	factory := p.factory
@@ -120,44 +242,190 @@ func (p *Pool) getConnsAndFactory() (chan net.Conn, Factory) {
 // conn is simply closed. A nil conn will be rejected.
 func (p *Pool) Return(conn net.Conn) error {
 	if conn == nil {
-		log.WithFields(log.Fields{
-			"Connection Id": conn,
-			"Address":       conn.RemoteAddr(),
-			"Error":         "connection is nil. rejecting",
-		}).Info("Returning connection to pool")
+		p.logger.Warnf("returning connection to pool: connection is nil, rejecting")
 		return errors.New("connection is nil. rejecting")
 	}
+	// conn didn't come in through a GConn we dialed, so we have no record of
+	// when it was actually created; treat it as new.
+	return p.returnConn(conn, time.Now())
+}
+
+// returnConn is the shared implementation behind Return and releaseConn. created
+// is the conn's original dial time, preserved across the idle channel so
+// MaxConnLifetime keeps being measured from when the conn was actually
+// dialed, not from this return.
+func (p *Pool) returnConn(conn net.Conn, created time.Time) error {
+	if p.closed.Load() {
+		p.logger.Debugf("pool closed, closing returned connection: address=%s", conn.RemoteAddr())
+		p.noteClosed()
+		return conn.Close()
+	}
 
 	p.mu.Lock()
-	defer p.mu.Unlock()
 
 	if p.conns == nil {
-		log.WithFields(log.Fields{
-			"Connection Id": conn,
-			"Address":       conn.RemoteAddr(),
-			"Error":         "pool is closed, close passed connection",
-		}).Info("Pool closed")
-		// pool is closed, close passed connection
+		p.mu.Unlock()
+		p.logger.Debugf("pool closed, closing returned connection: address=%s", conn.RemoteAddr())
+		p.noteClosed()
 		return conn.Close()
 	}
 
+	// hand the connection directly to the longest-waiting caller instead of
+	// cycling it through p.conns, so FIFO waiters get predictable latency.
+	if len(p.waiters) > 0 {
+		ch := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		p.mu.Unlock()
+		ch <- p.wrapConn(conn, created)
+		return nil
+	}
+
 	// put the resource back into the pool. If the pool is full, this will
-	// block and the default case will be executed.
+	// fall through to the default case below.
 	select {
-	case p.conns <- conn:
+	case p.conns <- &pooledConn{conn: conn, created: created, lastUsed: time.Now()}:
 		p.idleConns++
+		p.mu.Unlock()
 		return nil
 	default:
 		// pool is full, close passed connection
-		log.WithFields(log.Fields{
-			"Connection Id": conn,
-			"Address":       conn.RemoteAddr(),
-			"Error":         "pool is full, close passed connection",
-		}).Info("Pool Full")
+		p.mu.Unlock()
+		p.logger.Debugf("pool full, closing returned connection: address=%s", conn.RemoteAddr())
+		p.noteClosed()
 		return conn.Close()
 	}
 }
 
+// checkBorrow reports why pc should not be handed out as-is, or nil if it's
+// still good: either it has sat idle past IdleTimeout, or it fails the
+// configured TestOnBorrow hook.
+func (p *Pool) checkBorrow(pc *pooledConn) error {
+	if p.config.IdleTimeout > 0 && time.Since(pc.lastUsed) > p.config.IdleTimeout {
+		return errors.New("connection idle timeout exceeded")
+	}
+	if p.config.TestOnBorrow != nil {
+		return p.config.TestOnBorrow(pc.conn, pc.lastUsed)
+	}
+	return nil
+}
+
+// waitForConn parks the caller on the FIFO waiters queue until Return hands it
+// a connection, a slot is freed by a connection being permanently closed
+// (MarkUnusable, MaxConnLifetime, ...), WaitTimeout elapses, or ctx is done.
+func (p *Pool) waitForConn(ctx context.Context, factory Factory) (*GConn, error) {
+	ch := make(chan *GConn, 1)
+	p.mu.Lock()
+	if p.closed.Load() {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	p.waiters = append(p.waiters, ch)
+	p.waitCount++
+	p.mu.Unlock()
+
+	start := time.Now()
+	defer func() {
+		p.mu.Lock()
+		p.waitDuration += time.Since(start)
+		p.mu.Unlock()
+	}()
+
+	var timeoutCh <-chan time.Time
+	if p.config.WaitTimeout > 0 {
+		timer := time.NewTimer(p.config.WaitTimeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case conn := <-ch:
+		if conn == nil {
+			return nil, ErrClosed
+		}
+		return conn, nil
+	case <-p.remainingSpace:
+		// a slot was freed by a connection that was permanently closed
+		// (MarkUnusable, past MaxConnLifetime, ...) rather than handed back
+		// via Return, so there's no live conn waiting for us on ch - dial a
+		// fresh one into the freed slot ourselves.
+		if !p.removeWaiter(ch) {
+			// Return concurrently popped us and is about to hand us a
+			// connection on ch anyway; that satisfies us directly, so give
+			// the freed slot back for the next waiter/Get to use.
+			p.addRemainingSpace()
+			if conn := <-ch; conn != nil {
+				return conn, nil
+			}
+			return nil, ErrClosed
+		}
+		return p.dialIntoFreedSlot(ctx, factory)
+	case <-timeoutCh:
+		if !p.removeWaiter(ch) {
+			// Return already popped us off the queue and is about to hand us
+			// a connection on ch; it's ours to give back to the pool since
+			// we're reporting a timeout to our caller instead.
+			p.returnHandoff(ch)
+			p.incTimeout()
+			return nil, errors.New("gpool: timed out waiting for a connection")
+		}
+		p.incTimeout()
+		return nil, errors.New("gpool: timed out waiting for a connection")
+	case <-ctx.Done():
+		if !p.removeWaiter(ch) {
+			p.returnHandoff(ch)
+			return nil, ctx.Err()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// returnHandoff blocks for the connection Return is already sending on ch -
+// ch was popped from p.waiters before we could remove ourselves, so Return
+// owns it and will deliver shortly - and releases it back to the pool, since
+// the caller we're unblocking is receiving an error, not this connection.
+func (p *Pool) returnHandoff(ch chan *GConn) {
+	if conn := <-ch; conn != nil {
+		conn.Close()
+	}
+}
+
+// removeWaiter drops ch from the waiters queue, reporting whether it was
+// still there. false means Return already popped it and owns delivering to
+// ch, so the caller must not assume ch will stay empty.
+func (p *Pool) removeWaiter(ch chan *GConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, w := range p.waiters {
+		if w == ch {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// dialIntoFreedSlot dials a new connection into a slot whose remainingSpace
+// token has already been consumed by the caller, rolling the token back if
+// the dial fails.
+func (p *Pool) dialIntoFreedSlot(ctx context.Context, factory Factory) (*GConn, error) {
+	p.mu.Lock()
+	p.numOpen++
+	p.mu.Unlock()
+	conn, err := factory(ctx, p.config.Network, p.config.Address)
+	if err != nil {
+		p.mu.Lock()
+		p.numOpen--
+		p.mu.Unlock()
+		p.addRemainingSpace()
+		return nil, err
+	}
+	p.mu.Lock()
+	p.createdTotal++
+	p.mu.Unlock()
+	p.logger.Debugf("dialed connection into freed slot: address=%s", p.config.Address)
+	return p.wrapConn(conn, time.Now()), nil
+}
+
 // Get implement Pool get interface
 // if don't have any connection available, it will try to new one
 func (p *Pool) Get() (*GConn, error) {
@@ -167,40 +435,60 @@ func (p *Pool) Get() (*GConn, error) {
 	}
 	// wrap our connections with out custom net.Conn implementation (wrapConn
 	// method) that puts the connection back to the pool if it's closed.
-	select {
-	case conn := <-conns:
-		if conn == nil {
-			return nil, ErrClosed
-		}
-		log.WithFields(log.Fields{
-			"Connection Id": conn,
-			"Address":       conn.RemoteAddr(),
-			"Status":        "found existing connection",
-		}).Info("Get Connection")
-		p.mu.Lock()
-		p.idleConns--
-		p.mu.Unlock()
-		return p.wrapConn(conn), nil
-	default:
-		p.mu.Lock()
-		defer p.mu.Unlock()
-		p.createNum++
-		if p.createNum > p.config.MaxCap {
-			return nil, errors.New("More than MaxCap")
-		}
-		conn, err := factory(p.config.Network, p.config.Address)
-		log.WithFields(log.Fields{
-			"Connection Id": conn,
-			"Address":       conn.RemoteAddr(),
-			"Status":        "no connection found, creating new one",
-		}).Info("Get Connection")
-		p.removeRemainingSpace()
-		if err != nil {
-			p.addRemainingSpace()
-			return nil, err
-		}
+	for {
+		select {
+		case pc := <-conns:
+			if pc == nil {
+				return nil, ErrClosed
+			}
+			if reason := p.checkBorrow(pc); reason != nil {
+				p.logger.Debugf("discarding stale connection, redialing: address=%s reason=%v", pc.conn.RemoteAddr(), reason)
+				pc.conn.Close()
+				p.mu.Lock()
+				p.idleConns--
+				p.mu.Unlock()
+				p.noteClosed()
+				conn, err := factory(context.Background(), p.config.Network, p.config.Address)
+				if err != nil {
+					p.addRemainingSpace()
+					return nil, err
+				}
+				p.noteDialed()
+				return p.wrapConn(conn, time.Now()), nil
+			}
+			p.logger.Debugf("get connection: address=%s status=found existing connection", pc.conn.RemoteAddr())
+			p.mu.Lock()
+			p.idleConns--
+			p.mu.Unlock()
+			return p.wrapConn(pc.conn, pc.created), nil
+		default:
+			p.mu.Lock()
+			p.numOpen++
+			if p.numOpen > p.config.MaxCap {
+				p.numOpen--
+				p.mu.Unlock()
+				if p.config.WaitTimeout <= 0 {
+					return nil, errors.New("More than MaxCap")
+				}
+				return p.waitForConn(context.Background(), factory)
+			}
+			p.mu.Unlock()
+			conn, err := factory(context.Background(), p.config.Network, p.config.Address)
+			p.logger.Debugf("get connection: address=%s status=no connection found, creating new one", p.config.Address)
+			p.removeRemainingSpace()
+			if err != nil {
+				p.mu.Lock()
+				p.numOpen--
+				p.mu.Unlock()
+				p.addRemainingSpace()
+				return nil, err
+			}
+			p.mu.Lock()
+			p.createdTotal++
+			p.mu.Unlock()
 
-		return p.wrapConn(conn), nil
+			return p.wrapConn(conn, time.Now()), nil
+		}
 	}
 }
 
@@ -219,38 +507,43 @@ func (p *Pool) BlockingGet(ctx context.Context) (*GConn, error) {
 
 	// wrap our connections with out custom net.Conn implementation (wrapConn
 	// method) that puts the connection back to the pool if it's closed.
-	select {
-	case conn := <-conns:
-		if conn == nil {
-			return nil, ErrClosed
-		}
-		log.WithFields(log.Fields{
-			"Connection Id": conn,
-			"Address":       conn.RemoteAddr(),
-			"Status":        "found existing connection",
-		}).Info("BlockingGet Connection")
-		p.mu.Lock()
-		p.idleConns--
-		p.mu.Unlock()
-		return p.wrapConn(conn), nil
-	case _ = <-p.remainingSpace:
-		p.mu.Lock()
-		defer p.mu.Unlock()
-		p.createNum++
-		conn, err := factory(p.config.Network, p.config.Address)
-		if err != nil {
-			p.addRemainingSpace()
-			return nil, err
+	for {
+		select {
+		case pc := <-conns:
+			if pc == nil {
+				return nil, ErrClosed
+			}
+			if reason := p.checkBorrow(pc); reason != nil {
+				p.logger.Debugf("discarding stale connection, redialing: address=%s reason=%v", pc.conn.RemoteAddr(), reason)
+				pc.conn.Close()
+				p.mu.Lock()
+				p.idleConns--
+				p.mu.Unlock()
+				p.noteClosed()
+				conn, err := factory(ctx, p.config.Network, p.config.Address)
+				if err != nil {
+					p.addRemainingSpace()
+					return nil, err
+				}
+				p.noteDialed()
+				return p.wrapConn(conn, time.Now()), nil
+			}
+			p.logger.Debugf("blockingget connection: address=%s status=found existing connection", pc.conn.RemoteAddr())
+			p.mu.Lock()
+			p.idleConns--
+			p.mu.Unlock()
+			return p.wrapConn(pc.conn, pc.created), nil
+		case _ = <-p.remainingSpace:
+			p.logger.Debugf("blockingget connection: address=%s status=no connection found, creating new one", p.config.Address)
+			return p.dialIntoFreedSlot(ctx, factory)
+		//if context deadline is reached, return timeout error
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			// no idle connection and no remainingSpace token available right
+			// now - join the FIFO wait queue instead of spinning.
+			return p.waitForConn(ctx, factory)
 		}
-		log.WithFields(log.Fields{
-			"Connection Id": conn,
-			"Address":       conn.RemoteAddr(),
-			"Status":        "no connection found, creating new one",
-		}).Info("BlockingGet Connection")
-		return p.wrapConn(conn), nil
-	//if context deadline is reached, return timeout error
-	case <-ctx.Done():
-		return nil, ctx.Err()
 	}
 }
 
@@ -258,18 +551,29 @@ func (p *Pool) BlockingGet(ctx context.Context) (*GConn, error) {
 // it will close all the connection in the pool
 func (p *Pool) Close() {
 	p.mu.Lock()
+	if !p.closed.CompareAndSwap(false, true) {
+		p.mu.Unlock()
+		return
+	}
 	conns := p.conns
 	p.conns = nil
 	p.factory = nil
+	waiters := p.waiters
+	p.waiters = nil
 	p.mu.Unlock()
 
+	for _, ch := range waiters {
+		ch <- nil
+	}
+
 	if conns == nil {
 		return
 	}
 
 	close(conns)
-	for conn := range conns {
-		conn.Close()
+	for pc := range conns {
+		pc.conn.Close()
+		p.noteClosed()
 		p.addRemainingSpace()
 	}
 }
@@ -0,0 +1,291 @@
+package pool
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestListener starts a TCP echo-less listener that just accepts and holds
+// connections open, so the pool under test has something real to dial.
+func newTestListener(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				// keep the connection open until the other side closes it.
+				buf := make([]byte, 1)
+				for {
+					if _, err := c.Read(buf); err != nil {
+						c.Close()
+						return
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln
+}
+
+// TestPoolConcurrentGetReturnClose stresses Get/Return racing a concurrent
+// Close, guarding against the closed-channel send panic and double-close
+// accounting bugs this pool has previously had. Run with -race.
+func TestPoolConcurrentGetReturnClose(t *testing.T) {
+	ln := newTestListener(t)
+	defer ln.Close()
+
+	p, err := New(&Config{
+		Network: "tcp",
+		Address: ln.Addr().String(),
+		InitCap: 4,
+		MaxCap:  16,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	const workers = 32
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				conn, err := p.BlockingGet(context.Background())
+				if err != nil {
+					// the pool may legitimately be closed mid-run.
+					return
+				}
+				conn.Close()
+			}
+		}()
+	}
+
+	// close concurrently with the workers still hammering Get/Return.
+	time.AfterFunc(5*time.Millisecond, p.Close)
+
+	wg.Wait()
+}
+
+// TestWaiterWakesOnMarkUnusableFreedSlot reproduces a waiter parked
+// indefinitely (WaitTimeout == 0, so it only wakes on a connection handoff or
+// a freed slot) while the slot it needs is freed by a MarkUnusable'd
+// connection rather than a plain Return. Before the fix, only Return's
+// waiter handoff woke parked callers, so this waiter would block forever
+// despite the pool having free capacity.
+func TestWaiterWakesOnMarkUnusableFreedSlot(t *testing.T) {
+	ln := newTestListener(t)
+	defer ln.Close()
+
+	p, err := New(&Config{
+		Network: "tcp",
+		Address: ln.Addr().String(),
+		InitCap: 0,
+		MaxCap:  1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	conn1, err := p.BlockingGet(context.Background())
+	if err != nil {
+		t.Fatalf("BlockingGet 1: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		conn2, err := p.BlockingGet(context.Background())
+		if err == nil {
+			conn2.Close()
+		}
+		done <- err
+	}()
+
+	// give the second caller time to park on the wait queue before freeing
+	// the slot.
+	time.Sleep(5 * time.Millisecond)
+	conn1.MarkUnusable()
+	if err := conn1.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("waiter did not get a connection after its slot was freed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter never woke up after MarkUnusable freed its slot")
+	}
+}
+
+// TestWaitTimeoutHandoffNotLeaked stresses the race between a waiter's
+// WaitTimeout firing and Return concurrently handing it a connection: Return
+// pops the waiter off the queue first, so if the timeout fires in the window
+// before the send lands, the connection must be returned to the pool instead
+// of silently discarded. Before that fix this permanently shrank effective
+// capacity under contention.
+func TestWaitTimeoutHandoffNotLeaked(t *testing.T) {
+	ln := newTestListener(t)
+	defer ln.Close()
+
+	const maxCap = 2
+	p, err := New(&Config{
+		Network:     "tcp",
+		Address:     ln.Addr().String(),
+		InitCap:     maxCap,
+		MaxCap:      maxCap,
+		WaitTimeout: time.Microsecond,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	const workers = 20
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				conn, err := p.BlockingGet(context.Background())
+				if err != nil {
+					continue
+				}
+				conn.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// if a handed-off connection had been discarded instead of returned, it
+	// would still be counted in numOpen and hold its remainingSpace slot, so
+	// the pool would be left permanently short and one of these would fail.
+	got := make([]*GConn, 0, maxCap)
+	for i := 0; i < maxCap; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		conn, err := p.BlockingGet(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("Get %d after stress: %v", i, err)
+		}
+		got = append(got, conn)
+	}
+	for _, conn := range got {
+		conn.Close()
+	}
+}
+
+// TestMaxConnLifetimeAnchoredToDialTime verifies MaxConnLifetime is measured
+// from when a connection was actually dialed, not from its last return: it
+// cycles one connection through several short Get/Close rounds, each well
+// under MaxConnLifetime, and expects the cumulative age to still trip it.
+func TestMaxConnLifetimeAnchoredToDialTime(t *testing.T) {
+	ln := newTestListener(t)
+	defer ln.Close()
+
+	p, err := New(&Config{
+		Network:         "tcp",
+		Address:         ln.Addr().String(),
+		InitCap:         1,
+		MaxCap:          1,
+		MaxConnLifetime: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 5; i++ {
+		conn, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if err := conn.Close(); err != nil {
+			t.Fatalf("Close %d: %v", i, err)
+		}
+	}
+
+	if stats := p.Stats(); stats.Closed == 0 {
+		t.Fatalf("expected MaxConnLifetime to have closed the original connection by now, stats=%+v", stats)
+	}
+}
+
+// TestStaleConnDiscardDecrementsIdleConns verifies idleConns is decremented
+// when a stale (IdleTimeout/TestOnBorrow-rejected) connection is pulled off
+// p.conns, just like the non-stale borrow path does, so Stats().Idle/InUse
+// don't drift after an eviction.
+func TestStaleConnDiscardDecrementsIdleConns(t *testing.T) {
+	ln := newTestListener(t)
+	defer ln.Close()
+
+	p, err := New(&Config{
+		Network:     "tcp",
+		Address:     ln.Addr().String(),
+		InitCap:     1,
+		MaxCap:      1,
+		IdleTimeout: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	// let the one idle connection go stale.
+	time.Sleep(5 * time.Millisecond)
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if idle := p.Idle(); idle != 1 {
+		t.Fatalf("expected idleConns to settle back to 1 after stale eviction + return, got %d", idle)
+	}
+}
+
+// TestGConnCloseIdempotent verifies double-Close and Close-after-MarkUnusable
+// only ever release the underlying connection once.
+func TestGConnCloseIdempotent(t *testing.T) {
+	ln := newTestListener(t)
+	defer ln.Close()
+
+	p, err := New(&Config{
+		Network: "tcp",
+		Address: ln.Addr().String(),
+		InitCap: 1,
+		MaxCap:  1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer p.Close()
+
+	conn, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	conn.MarkUnusable()
+	if err := conn.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("second Close should be a no-op, got: %v", err)
+	}
+}
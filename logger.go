@@ -0,0 +1,19 @@
+package pool
+
+// Logger is a minimal logging interface pools accept so callers aren't forced
+// to pull in a concrete logging library. It defaults to a no-op
+// implementation when not configured.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// noopLogger discards everything; it's the default Logger for Config/MultiPoolConfig.
+type noopLogger struct{}
+
+func (noopLogger) Debugf(string, ...interface{}) {}
+func (noopLogger) Infof(string, ...interface{})  {}
+func (noopLogger) Warnf(string, ...interface{})  {}
+func (noopLogger) Errorf(string, ...interface{}) {}
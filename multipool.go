@@ -0,0 +1,224 @@
+package pool
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// MultiPoolConfig configures a MultiPool.
+type MultiPoolConfig struct {
+	// IdleTimeout is how long an idle connection may sit in a bucket before
+	// the reaper closes it. Zero disables idle eviction.
+	IdleTimeout time.Duration
+	// MaxLifetime is the maximum age of a connection, regardless of how
+	// recently it was used. Zero disables lifetime eviction.
+	MaxLifetime time.Duration
+	// ReapInterval is how often the reaper walks the buckets. Defaults to
+	// one minute when zero or negative.
+	ReapInterval time.Duration
+	// Logger receives the pool's debug/operational chatter. Defaults to a
+	// no-op logger.
+	Logger Logger
+}
+
+// multiEntry is a single idle connection sitting in a MultiPool bucket.
+type multiEntry struct {
+	conn     net.Conn
+	created  time.Time
+	lastUsed time.Time
+}
+
+// MultiPool manages idle connections keyed by destination (e.g. "network|address")
+// instead of being locked to a single Config.Address, so a fan-out client can
+// share one pool across many remote peers.
+type MultiPool struct {
+	mu         sync.Mutex
+	buckets    map[string][]*multiEntry
+	config     *MultiPoolConfig
+	logger     Logger
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+	closed     bool
+}
+
+// NewMultiPool creates a MultiPool and starts its background reaper.
+func NewMultiPool(mc *MultiPoolConfig) *MultiPool {
+	if mc.ReapInterval <= 0 {
+		mc.ReapInterval = time.Minute
+	}
+	logger := mc.Logger
+	if logger == nil {
+		logger = noopLogger{}
+	}
+	mp := &MultiPool{
+		buckets:    make(map[string][]*multiEntry),
+		config:     mc,
+		logger:     logger,
+		shutdownCh: make(chan struct{}),
+	}
+	mp.wg.Add(1)
+	go mp.reapLoop()
+	return mp
+}
+
+// AcquireOrDial returns an idle connection matching key, if one is available,
+// otherwise it dials a new one via dialer. The returned GConn puts the
+// connection back into the key's bucket on Close.
+func (mp *MultiPool) AcquireOrDial(ctx context.Context, key string, dialer func() (net.Conn, error)) (*GConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mp.mu.Lock()
+	if mp.closed {
+		mp.mu.Unlock()
+		return nil, ErrClosed
+	}
+	bucket := mp.buckets[key]
+	if n := len(bucket); n > 0 {
+		entry := bucket[n-1]
+		mp.buckets[key] = bucket[:n-1]
+		mp.mu.Unlock()
+		mp.logger.Debugf("acquireordial: key=%s status=found idle connection", key)
+		return mp.wrapConn(key, entry.conn, entry.created), nil
+	}
+	mp.mu.Unlock()
+
+	conn, err := dialer()
+	if err != nil {
+		return nil, err
+	}
+	mp.logger.Debugf("acquireordial: key=%s status=dialed new connection", key)
+	return mp.wrapConn(key, conn, time.Now()), nil
+}
+
+// wrapConn wraps conn in a GConn that returns itself to key's bucket on Close.
+func (mp *MultiPool) wrapConn(key string, conn net.Conn, created time.Time) *GConn {
+	return &GConn{
+		Conn:    conn,
+		owner:   &multiPoolBucket{mp: mp, key: key},
+		created: created,
+		logger:  mp.logger,
+	}
+}
+
+// releaseConn puts conn back into key's bucket, or closes it if it was marked
+// unusable or the pool has since been closed.
+func (mp *MultiPool) releaseConn(key string, conn net.Conn, created time.Time, unusable bool) error {
+	if unusable {
+		return conn.Close()
+	}
+
+	mp.mu.Lock()
+	if mp.closed {
+		mp.mu.Unlock()
+		return conn.Close()
+	}
+	mp.buckets[key] = append(mp.buckets[key], &multiEntry{
+		conn:     conn,
+		created:  created,
+		lastUsed: time.Now(),
+	})
+	mp.mu.Unlock()
+	return nil
+}
+
+// reapLoop periodically evicts idle/expired connections until Close is called.
+func (mp *MultiPool) reapLoop() {
+	defer mp.wg.Done()
+
+	ticker := time.NewTicker(mp.config.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			mp.reapOnce()
+		case <-mp.shutdownCh:
+			return
+		}
+	}
+}
+
+// reapOnce walks every bucket once, closing entries that exceeded IdleTimeout
+// or MaxLifetime and coalescing buckets left empty.
+func (mp *MultiPool) reapOnce() {
+	now := time.Now()
+
+	mp.mu.Lock()
+	keys := make([]string, 0, len(mp.buckets))
+	for k := range mp.buckets {
+		keys = append(keys, k)
+	}
+	mp.mu.Unlock()
+
+	for _, key := range keys {
+		mp.reapBucket(key, now)
+	}
+}
+
+func (mp *MultiPool) reapBucket(key string, now time.Time) {
+	mp.mu.Lock()
+	entries := mp.buckets[key]
+	if len(entries) == 0 {
+		mp.mu.Unlock()
+		return
+	}
+
+	kept := entries[:0]
+	var stale []*multiEntry
+	for _, e := range entries {
+		idleExpired := mp.config.IdleTimeout > 0 && now.Sub(e.lastUsed) > mp.config.IdleTimeout
+		tooOld := mp.config.MaxLifetime > 0 && now.Sub(e.created) > mp.config.MaxLifetime
+		if idleExpired || tooOld {
+			stale = append(stale, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == 0 {
+		delete(mp.buckets, key)
+	} else {
+		mp.buckets[key] = kept
+	}
+	mp.mu.Unlock()
+
+	for _, e := range stale {
+		e.conn.Close()
+	}
+}
+
+// Close stops the reaper and closes every idle connection in every bucket.
+func (mp *MultiPool) Close() {
+	mp.mu.Lock()
+	if mp.closed {
+		mp.mu.Unlock()
+		return
+	}
+	mp.closed = true
+	buckets := mp.buckets
+	mp.buckets = nil
+	mp.mu.Unlock()
+
+	close(mp.shutdownCh)
+	mp.wg.Wait()
+
+	for _, entries := range buckets {
+		for _, e := range entries {
+			e.conn.Close()
+		}
+	}
+}
+
+// multiPoolBucket adapts a (MultiPool, key) pair to connOwner so GConn.Close
+// returns the connection to the right bucket.
+type multiPoolBucket struct {
+	mp  *MultiPool
+	key string
+}
+
+func (b *multiPoolBucket) releaseConn(conn net.Conn, created time.Time, unusable bool) error {
+	return b.mp.releaseConn(b.key, conn, created, unusable)
+}